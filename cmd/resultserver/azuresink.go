@@ -0,0 +1,108 @@
+/*
+Copyright © 2019 Red Hat Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// azureSink streams results into an Azure Blob Storage container, selected
+// via an azblob://container/prefix?account=myaccount --sink URI.
+type azureSink struct {
+	client    *azblob.Client
+	account   string
+	container string
+	prefix    string
+}
+
+func newAzureSink(u *url.URL) (*azureSink, error) {
+	account := u.Query().Get("account")
+	if account == "" {
+		return nil, fmt.Errorf("azblob sink requires an ?account= query parameter")
+	}
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve Azure credentials: %w", err)
+	}
+	client, err := azblob.NewClient(fmt.Sprintf("https://%s.blob.core.windows.net/", account), cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Azure Blob client: %w", err)
+	}
+	return &azureSink{client: client, account: account, container: u.Host, prefix: strings.TrimPrefix(u.Path, "/")}, nil
+}
+
+func (s *azureSink) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *azureSink) Create(name string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.client.UploadStream(context.Background(), s.container, s.key(name)+".partial", pr, nil)
+		done <- err
+	}()
+	return &azureUpload{pw: pw, done: done}, nil
+}
+
+func (s *azureSink) Finalize(name string, _ Metadata) error {
+	ctx := context.Background()
+	srcURL := fmt.Sprintf("https://%s.blob.core.windows.net/%s/%s.partial", s.account, s.container, s.key(name))
+	if _, err := s.client.CopyFromURL(ctx, s.container, s.key(name), srcURL, nil); err != nil {
+		return fmt.Errorf("unable to commit %s: %w", name, err)
+	}
+	// name is already committed at this point; a failure to clean up the
+	// .partial blob is a storage-hygiene issue, not an upload failure.
+	if _, err := s.client.DeleteBlob(ctx, s.container, s.key(name)+".partial", nil); err != nil {
+		log.Printf("Committed %s but failed to remove its .partial blob: %v", name, err)
+	}
+	return nil
+}
+
+func (s *azureSink) Abort(name string) error {
+	_, err := s.client.DeleteBlob(context.Background(), s.container, s.key(name)+".partial", nil)
+	return err
+}
+
+// azureUpload adapts the write side of an UploadStream's pipe into the
+// io.WriteCloser that ResultSink.Create promises, waiting for the upload
+// goroutine to finish before reporting Close as done.
+type azureUpload struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (u *azureUpload) Write(p []byte) (int, error) {
+	return u.pw.Write(p)
+}
+
+func (u *azureUpload) Close() error {
+	if err := u.pw.Close(); err != nil {
+		return err
+	}
+	return <-u.done
+}
@@ -0,0 +1,85 @@
+/*
+Copyright © 2019 Red Hat Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Rejection reasons reported on the reportsRejected counter.
+const (
+	reasonMissingHeader  = "missing_header"
+	reasonBadEncoding    = "bad_encoding"
+	reasonDigestMismatch = "digest_mismatch"
+	reasonWriteError     = "write_error"
+)
+
+var (
+	reportsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "resultserver_reports_received_total",
+		Help: "Number of SCAP result reports successfully received and committed, by scan index.",
+	}, []string{"scan_index"})
+
+	reportsRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "resultserver_reports_rejected_total",
+		Help: "Number of SCAP result reports rejected, by scan index and reason.",
+	}, []string{"scan_index", "reason"})
+
+	bytesWritten = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "resultserver_bytes_written_total",
+		Help: "Bytes written for successfully received reports, by scan index.",
+	}, []string{"scan_index"})
+
+	inFlightRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "resultserver_in_flight_requests",
+		Help: "Number of report upload requests currently being handled.",
+	})
+)
+
+// startDiagnosticsServer serves /healthz, /readyz, /metrics and
+// /debug/pprof/* on their own plaintext listener, separate from the mTLS
+// listener used for scanner uploads, so cluster monitoring can scrape
+// freely without needing a client certificate. A no-op if addr is empty.
+func startDiagnosticsServer(addr string) {
+	if addr == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	go func() {
+		log.Printf("Serving diagnostics on %s", addr)
+		log.Printf("Diagnostics listener exited: %v", http.ListenAndServe(addr, mux))
+	}()
+}
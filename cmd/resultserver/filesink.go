@@ -0,0 +1,55 @@
+/*
+Copyright © 2019 Red Hat Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// fileSink is the original resultserver behavior: results are written
+// directly to a local directory, typically a mounted PVC.
+type fileSink struct {
+	basePath string
+}
+
+func newFileSink(basePath string) (*fileSink, error) {
+	if err := ensureDir(basePath); err != nil {
+		return nil, err
+	}
+	return &fileSink{basePath: basePath}, nil
+}
+
+func (s *fileSink) partialPath(name string) string {
+	return filepath.Join(s.basePath, name+".partial")
+}
+
+func (s *fileSink) finalPath(name string) string {
+	return filepath.Join(s.basePath, name)
+}
+
+func (s *fileSink) Create(name string) (io.WriteCloser, error) {
+	return os.Create(s.partialPath(name))
+}
+
+func (s *fileSink) Finalize(name string, _ Metadata) error {
+	return os.Rename(s.partialPath(name), s.finalPath(name))
+}
+
+func (s *fileSink) Abort(name string) error {
+	return os.Remove(s.partialPath(name))
+}
@@ -0,0 +1,75 @@
+/*
+Copyright © 2019 Red Hat Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// gcsSink streams results into a Google Cloud Storage bucket, selected via
+// a gs://bucket/prefix --sink URI.
+type gcsSink struct {
+	client *storage.Client
+	bucket string
+	prefix string
+}
+
+func newGCSSink(u *url.URL) (*gcsSink, error) {
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("unable to create GCS client: %w", err)
+	}
+	return &gcsSink{client: client, bucket: u.Host, prefix: strings.TrimPrefix(u.Path, "/")}, nil
+}
+
+func (s *gcsSink) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *gcsSink) Create(name string) (io.WriteCloser, error) {
+	w := s.client.Bucket(s.bucket).Object(s.key(name) + ".partial").NewWriter(context.Background())
+	return w, nil
+}
+
+func (s *gcsSink) Finalize(name string, _ Metadata) error {
+	ctx := context.Background()
+	bucket := s.client.Bucket(s.bucket)
+	src := bucket.Object(s.key(name) + ".partial")
+	dst := bucket.Object(s.key(name))
+	if _, err := dst.CopierFrom(src).Run(ctx); err != nil {
+		return fmt.Errorf("unable to commit %s: %w", name, err)
+	}
+	// name is already committed at this point; a failure to clean up the
+	// .partial object is a storage-hygiene issue, not an upload failure.
+	if err := src.Delete(ctx); err != nil {
+		log.Printf("Committed %s but failed to remove its .partial object: %v", name, err)
+	}
+	return nil
+}
+
+func (s *gcsSink) Abort(name string) error {
+	return s.client.Bucket(s.bucket).Object(s.key(name) + ".partial").Delete(context.Background())
+}
@@ -0,0 +1,109 @@
+/*
+Copyright © 2019 Red Hat Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+)
+
+// errConnServed is returned by singleConnListener's second Accept, once the
+// one connection it hands out has been fully handled, so serveInherited can
+// tell a clean single-request exit apart from a real listener error.
+var errConnServed = errors.New("singleConnListener: connection already served")
+
+// serveInherited performs the TLS handshake on the already-connected socket
+// handed in on fd and serves a single request with srv's handler, for
+// deployments where resultserver is launched per-connection by systemd
+// socket activation or an ucspi-tls-style super-server instead of running
+// as a long-lived Deployment. It returns nil once that single connection
+// has been fully handled, or the error that aborted it.
+func serveInherited(srv *http.Server, fd int) error {
+	f := os.NewFile(uintptr(fd), "inherited-socket")
+	conn, err := net.FileConn(f)
+	if err != nil {
+		return fmt.Errorf("unable to use fd %d as a socket: %w", fd, err)
+	}
+
+	l := newSingleConnListener(conn)
+	prevConnState := srv.ConnState
+	srv.ConnState = func(c net.Conn, state http.ConnState) {
+		if prevConnState != nil {
+			prevConnState(c, state)
+		}
+		if state == http.StateClosed || state == http.StateHijacked {
+			l.Close()
+		}
+	}
+
+	err = srv.ServeTLS(l, "", "")
+	if errors.Is(err, errConnServed) {
+		return nil
+	}
+	return err
+}
+
+// singleConnListener is a net.Listener that yields exactly one connection,
+// so it can be handed to http.Server.Serve/ServeTLS to drive a single
+// inherited connection through the normal request-handling and
+// TLS-handshake machinery. The second Accept call blocks until the served
+// connection is closed (signaled via Close, wired to srv.ConnState above),
+// so Serve only returns once the request has actually finished.
+type singleConnListener struct {
+	conn net.Conn
+	addr net.Addr
+
+	served chan struct{} // closed once Accept has handed out conn
+	done   chan struct{} // closed once the served connection is done
+}
+
+func newSingleConnListener(conn net.Conn) *singleConnListener {
+	return &singleConnListener{
+		conn:   conn,
+		addr:   conn.LocalAddr(),
+		served: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	select {
+	case <-l.served:
+		<-l.done
+		return nil, errConnServed
+	default:
+	}
+	close(l.served)
+	return l.conn, nil
+}
+
+// Close signals that the served connection is done; safe to call more than
+// once or concurrently.
+func (l *singleConnListener) Close() error {
+	select {
+	case <-l.done:
+	default:
+		close(l.done)
+	}
+	return nil
+}
+
+func (l *singleConnListener) Addr() net.Addr {
+	return l.addr
+}
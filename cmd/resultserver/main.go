@@ -16,56 +16,119 @@ limitations under the License.
 package main
 
 import (
+	"crypto/sha256"
 	"crypto/tls"
-	"crypto/x509"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
-	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
 	libgocrypto "github.com/openshift/library-go/pkg/crypto"
 )
 
+// defaultAcceptedEncodings are the Content-Encoding values resultserver
+// accepts when the operator doesn't restrict this via --accepted-encodings.
+var defaultAcceptedEncodings = []string{"", "bzip2", "gzip", "zstd"}
+
 func defineFlags(cmd *cobra.Command) {
 	cmd.Flags().String("address", "1.1.1.1", "Server address")
 	cmd.Flags().String("port", "8443", "Server port")
 	cmd.Flags().String("path", "/", "Content path")
 	cmd.Flags().String("owner", "", "Object owner")
 	cmd.Flags().String("scan-index", "", "The current index of the scan")
-	cmd.Flags().String("tls-server-cert", "", "Path to the server cert")
+	cmd.Flags().String("tls-server-cert", "", "Path to the server cert. If unset, along with --tls-server-key and --tls-ca, an ephemeral CA is provisioned under --pki-dir")
 	cmd.Flags().String("tls-server-key", "", "Path to the server key")
 	cmd.Flags().String("tls-ca", "", "Path to the CA certificate")
+	cmd.Flags().String("pki-dir", "/etc/resultserver/pki", "Directory where the auto-provisioned CA, cert and key are kept")
+	cmd.Flags().Duration("tls-cert-refresh", 10*time.Minute, "How often to check --pki-dir for rotated certificates")
+	cmd.Flags().StringSlice("accepted-encodings", defaultAcceptedEncodings,
+		"Content-Encoding values this server will accept, in addition to an empty one")
+	cmd.Flags().Bool("stdio", false, "Serve a single request over a TLS connection inherited on fd 3, for socket-activated deployments, instead of listening on --address/--port")
+	cmd.Flags().Int("inherit-fd", 3, "File descriptor of the already-connected socket to serve when --stdio is set")
+	cmd.Flags().String("sink", "", "URI of the storage backend to write results to (file:///reports, s3://bucket/prefix?region=..., gs://bucket/prefix, azblob://container/prefix?account=...). Defaults to a file:// sink rooted at --path/--scan-index")
+	cmd.Flags().String("spiffe-trust-domain", "", "SPIFFE trust domain (e.g. example.org) scanner clients must belong to. Enables SPIFFE authentication via the SPIFFE Workload API instead of --tls-ca")
+	cmd.Flags().StringSlice("spiffe-allowed-id", nil, "SPIFFE ID (spiffe://trust-domain/path) allowed to upload results, repeatable. Narrows --spiffe-trust-domain to specific workloads")
+	cmd.Flags().String("diagnostic-addr", "", "Address (host:port) for a plaintext listener serving /healthz, /readyz, /metrics and /debug/pprof/*, kept separate from the mTLS scanner-upload listener. Disabled if empty")
 }
 
 type config struct {
-	Address string
-	Port    string
-	Path    string
-	Cert    string
-	Key     string
-	CA      string
+	Address           string
+	Port              string
+	Path              string
+	ScanIndex         string
+	Cert              string
+	Key               string
+	CA                string
+	PKIDir            string
+	CertRefresh       time.Duration
+	AcceptedEncodings map[string]bool
+	Stdio             bool
+	InheritFD         int
+	Sink              string
+	SpiffeTrustDomain string
+	SpiffeAllowedIDs  []string
+	DiagnosticAddr    string
 }
 
 func parseConfig(cmd *cobra.Command) *config {
 	basePath := getValidStringArg(cmd, "path")
 	index := getValidStringArg(cmd, "scan-index")
+	encodings, _ := cmd.Flags().GetStringSlice("accepted-encodings")
+	refresh, _ := cmd.Flags().GetDuration("tls-cert-refresh")
+	pkiDir, _ := cmd.Flags().GetString("pki-dir")
+	cert, _ := cmd.Flags().GetString("tls-server-cert")
+	key, _ := cmd.Flags().GetString("tls-server-key")
+	ca, _ := cmd.Flags().GetString("tls-ca")
+	stdio, _ := cmd.Flags().GetBool("stdio")
+	inheritFD, _ := cmd.Flags().GetInt("inherit-fd")
+	sink, _ := cmd.Flags().GetString("sink")
+	spiffeTrustDomain, _ := cmd.Flags().GetString("spiffe-trust-domain")
+	spiffeAllowedIDs, _ := cmd.Flags().GetStringSlice("spiffe-allowed-id")
+	diagnosticAddr, _ := cmd.Flags().GetString("diagnostic-addr")
+
 	conf := &config{
-		Address: getValidStringArg(cmd, "address"),
-		Port:    getValidStringArg(cmd, "port"),
-		Path:    filepath.Join(basePath, index),
-		Cert:    getValidStringArg(cmd, "tls-server-cert"),
-		Key:     getValidStringArg(cmd, "tls-server-key"),
-		CA:      getValidStringArg(cmd, "tls-ca"),
+		Path:              filepath.Join(basePath, index),
+		ScanIndex:         index,
+		Cert:              cert,
+		Key:               key,
+		CA:                ca,
+		PKIDir:            pkiDir,
+		CertRefresh:       refresh,
+		AcceptedEncodings: toEncodingSet(encodings),
+		Stdio:             stdio,
+		InheritFD:         inheritFD,
+		Sink:              sink,
+		SpiffeTrustDomain: spiffeTrustDomain,
+		SpiffeAllowedIDs:  spiffeAllowedIDs,
+		DiagnosticAddr:    diagnosticAddr,
+	}
+	if !stdio {
+		conf.Address = getValidStringArg(cmd, "address")
+		conf.Port = getValidStringArg(cmd, "port")
 	}
 	return conf
 }
 
+// toEncodingSet builds the --accepted-encodings whitelist. An empty
+// Content-Encoding (an uncompressed upload) is always accepted, regardless
+// of what --accepted-encodings was restricted to, matching the flag's
+// documented behavior.
+func toEncodingSet(encodings []string) map[string]bool {
+	set := make(map[string]bool, len(encodings)+1)
+	set[""] = true
+	for _, e := range encodings {
+		set[e] = true
+	}
+	return set
+}
+
 func getValidStringArg(cmd *cobra.Command, name string) string {
 	val, _ := cmd.Flags().GetString(name)
 	if val == "" {
@@ -104,67 +167,150 @@ func main() {
 }
 
 func server(c *config) {
-	err := ensureDir(c.Path)
+	if c.Sink == "" {
+		c.Sink = "file://" + c.Path
+	}
+	sink, err := newResultSink(c.Sink)
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	caCert, err := ioutil.ReadFile(c.CA)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	var tlsConfig *tls.Config
+	if c.SpiffeTrustDomain != "" || len(c.SpiffeAllowedIDs) > 0 {
+		var err error
+		tlsConfig, err = spiffeTLSConfig(c)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	} else {
+		if c.Cert == "" && c.Key == "" && c.CA == "" {
+			if err := bootstrapPKI(c); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+		base := &tls.Config{}
+		// Configures TLS 1.2
+		base = libgocrypto.SecureTLSConfig(base)
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+
+		reloader, err := newCertReloader(c.Cert, c.Key, c.CA, c.CertRefresh, base)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		go reloader.watch()
+
+		base.GetCertificate = reloader.getCertificate
+		tlsConfig = base
+		tlsConfig.GetConfigForClient = reloader.getConfigForClient
 	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-
-	tlsConfig := &tls.Config{}
-	// Configures TLS 1.2
-	tlsConfig = libgocrypto.SecureTLSConfig(tlsConfig)
-	tlsConfig.ClientCAs = caCertPool
-	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
-	tlsConfig.BuildNameToCertificate()
-	server := &http.Server{
+
+	srv := &http.Server{
 		Addr:      c.Address + ":" + c.Port,
 		TLSConfig: tlsConfig,
+		Handler:   newHandler(c, sink),
+	}
+
+	startDiagnosticsServer(c.DiagnosticAddr)
+
+	if c.Stdio {
+		log.Printf("Serving a single request on inherited fd %d...", c.InheritFD)
+		if err := serveInherited(srv, c.InheritFD); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	log.Println("Listening...")
+	log.Fatal(srv.ListenAndServeTLS("", ""))
+}
+
+func newHandler(c *config, sink ResultSink) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		inFlightRequests.Inc()
+		defer inFlightRequests.Dec()
+
 		filename := r.Header.Get("X-Report-Name")
 		if filename == "" {
 			log.Println("Rejecting. No \"X-Report-Name\" header given.")
+			reportsRejected.WithLabelValues(c.ScanIndex, reasonMissingHeader).Inc()
 			http.Error(w, "Missing report name header", 400)
 			return
 		}
 		encoding := r.Header.Get("Content-Encoding")
-		extraExtension := encoding
-		if encoding != "" && encoding != "bzip2" {
+		if !c.AcceptedEncodings[encoding] {
 			log.Println("Rejecting. Invalid \"Content-Encoding\" header given.")
+			reportsRejected.WithLabelValues(c.ScanIndex, reasonBadEncoding).Inc()
 			http.Error(w, "invalid content encoding header", 400)
 			return
-		} else if encoding == "bzip2" {
+		}
+		extraExtension := encoding
+		if encoding == "bzip2" {
 			// if the results are compressed, they are also base64-encoded, let's make this clear to the user
 			extraExtension = "." + extraExtension + ".base64"
+		} else if encoding != "" {
+			extraExtension = "." + extraExtension
 		}
 		// TODO(jaosorior): Check that content-type is application/xml
-		filePath := path.Join(c.Path, filename+".xml"+extraExtension)
-		f, err := os.Create(filePath)
+		name := filename + ".xml" + extraExtension
+		w2, err := sink.Create(name)
 		if err != nil {
-			log.Printf("Error creating file: %s", filePath)
+			log.Printf("Error creating object %s: %v", name, err)
+			reportsRejected.WithLabelValues(c.ScanIndex, reasonWriteError).Inc()
 			http.Error(w, "Error creating file", 500)
 			return
 		}
-		// #nosec
-		defer f.Close()
 
-		_, err = io.Copy(f, r.Body)
+		digestHeader := r.Header.Get("X-Report-Digest")
+		hasher := sha256.New()
+		written, err := io.Copy(w2, io.TeeReader(r.Body, hasher))
 		if err != nil {
-			log.Printf("Error writing file %s", filePath)
+			log.Printf("Error writing object %s: %v", name, err)
+			w2.Close()
+			sink.Abort(name)
+			reportsRejected.WithLabelValues(c.ScanIndex, reasonWriteError).Inc()
 			http.Error(w, "Error writing file", 500)
 			return
 		}
-		log.Printf("Received file %s", filePath)
+
+		if digestHeader != "" {
+			expected := strings.TrimPrefix(digestHeader, "sha256:")
+			got := hex.EncodeToString(hasher.Sum(nil))
+			if !strings.EqualFold(expected, got) {
+				log.Printf("Rejecting %s. Digest mismatch: expected %s, got %s", name, expected, got)
+				w2.Close()
+				sink.Abort(name)
+				reportsRejected.WithLabelValues(c.ScanIndex, reasonDigestMismatch).Inc()
+				http.Error(w, "content integrity check failed", 422)
+				return
+			}
+		}
+
+		if err := w2.Close(); err != nil {
+			log.Printf("Error finishing write of %s: %v", name, err)
+			sink.Abort(name)
+			reportsRejected.WithLabelValues(c.ScanIndex, reasonWriteError).Inc()
+			http.Error(w, "Error writing file", 500)
+			return
+		}
+
+		meta := Metadata{Digest: digestHeader, Size: written}
+		if err := sink.Finalize(name, meta); err != nil {
+			log.Printf("Error finalizing %s: %v", name, err)
+			sink.Abort(name)
+			reportsRejected.WithLabelValues(c.ScanIndex, reasonWriteError).Inc()
+			http.Error(w, "Error writing file", 500)
+			return
+		}
+
+		reportsReceived.WithLabelValues(c.ScanIndex).Inc()
+		bytesWritten.WithLabelValues(c.ScanIndex).Add(float64(written))
+		log.Printf("Received file %s", name)
 	})
-	log.Println("Listening...")
-	log.Fatal(server.ListenAndServeTLS(c.Cert, c.Key))
+	return mux
 }
@@ -0,0 +1,65 @@
+/*
+Copyright © 2019 Red Hat Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandlerRejectsDigestMismatch(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := newFileSink(dir)
+	if err != nil {
+		t.Fatalf("newFileSink: %v", err)
+	}
+	c := &config{ScanIndex: "0", AcceptedEncodings: toEncodingSet(defaultAcceptedEncodings)}
+	handler := newHandler(c, sink)
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader("tampered content"))
+	req.Header.Set("X-Report-Name", "report")
+	req.Header.Set("X-Report-Digest", "sha256:0000000000000000000000000000000000000000000000000000000000000000")
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != 422 {
+		t.Fatalf("expected status 422, got %d", rr.Code)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "report.xml")); !os.IsNotExist(err) {
+		t.Fatalf("expected no committed file, stat returned: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "report.xml.partial")); !os.IsNotExist(err) {
+		t.Fatalf("expected the partial file to be removed, stat returned: %v", err)
+	}
+}
+
+func TestToEncodingSetAlwaysAcceptsEmpty(t *testing.T) {
+	set := toEncodingSet([]string{"gzip", "zstd"})
+	if !set[""] {
+		t.Fatal("expected an empty Content-Encoding to always be accepted")
+	}
+	if !set["gzip"] || !set["zstd"] {
+		t.Fatal("expected the requested encodings to be accepted")
+	}
+	if set["bzip2"] {
+		t.Fatal("did not expect an unrequested encoding to be accepted")
+	}
+}
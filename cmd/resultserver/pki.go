@@ -0,0 +1,201 @@
+/*
+Copyright © 2019 Red Hat Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	libgocrypto "github.com/openshift/library-go/pkg/crypto"
+)
+
+const (
+	caCertFile     = "ca.crt"
+	serverCertFile = "tls.crt"
+	serverKeyFile  = "tls.key"
+)
+
+// bootstrapPKI provisions an ephemeral self-signed CA and a server
+// certificate signed by it into c.PKIDir, and points c.Cert/c.Key/c.CA
+// at the resulting files. It is only invoked when the operator didn't
+// pass --tls-server-cert/--tls-server-key/--tls-ca, so the binary can
+// run standalone without a pre-baked PKI.
+func bootstrapPKI(c *config) error {
+	if err := ensureDir(c.PKIDir); err != nil {
+		return err
+	}
+
+	caCertPath := filepath.Join(c.PKIDir, caCertFile)
+	caKeyPath := filepath.Join(c.PKIDir, "ca.key")
+	ca, err := libgocrypto.MakeSelfSignedCAConfig("resultserver-ca", 365)
+	if err != nil {
+		return fmt.Errorf("unable to generate CA: %w", err)
+	}
+	if err := ca.WriteCertConfigFile(caCertPath, caKeyPath); err != nil {
+		return fmt.Errorf("unable to write CA to %s: %w", c.PKIDir, err)
+	}
+
+	caConfig, err := libgocrypto.GetCAFromFiles(caCertPath, caKeyPath)
+	if err != nil {
+		return fmt.Errorf("unable to load generated CA: %w", err)
+	}
+
+	hostnames := sets.NewString("localhost", "resultserver")
+	servingCert, err := caConfig.MakeServerCert(hostnames, 365)
+	if err != nil {
+		return fmt.Errorf("unable to generate server cert: %w", err)
+	}
+
+	serverCertPath := filepath.Join(c.PKIDir, serverCertFile)
+	serverKeyPath := filepath.Join(c.PKIDir, serverKeyFile)
+	if err := servingCert.WriteCertConfigFile(serverCertPath, serverKeyPath); err != nil {
+		return fmt.Errorf("unable to write server cert to %s: %w", c.PKIDir, err)
+	}
+
+	c.Cert = serverCertPath
+	c.Key = serverKeyPath
+	c.CA = caCertPath
+	log.Printf("Provisioned ephemeral PKI in %s", c.PKIDir)
+	return nil
+}
+
+// certReloader keeps the serving keypair and client CA pool up to date as
+// the underlying PEM files on disk are rotated, e.g. by cert-manager.
+type certReloader struct {
+	certPath string
+	keyPath  string
+	caPath   string
+	refresh  time.Duration
+
+	// base is the fully-built server TLS config (TLS 1.2 hardening,
+	// ClientAuth, GetCertificate) that getConfigForClient clones on every
+	// handshake. tls.Config.GetConfigForClient replaces the whole config
+	// for the connection rather than merging with it, so the returned
+	// config must carry everything the base config set, not just ClientCAs.
+	base *tls.Config
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+func newCertReloader(certPath, keyPath, caPath string, refresh time.Duration, base *tls.Config) (*certReloader, error) {
+	r := &certReloader{
+		certPath: certPath,
+		keyPath:  keyPath,
+		caPath:   caPath,
+		refresh:  refresh,
+		base:     base,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+	if err != nil {
+		return fmt.Errorf("unable to load server cert/key: %w", err)
+	}
+
+	caCert, err := ioutil.ReadFile(r.caPath)
+	if err != nil {
+		return fmt.Errorf("unable to load CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caCert)
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.pool = pool
+	r.mu.Unlock()
+	log.Println("Reloaded serving certificate and client CA pool")
+	return nil
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+func (r *certReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.RLock()
+	pool := r.pool
+	r.mu.RUnlock()
+	cfg := r.base.Clone()
+	cfg.ClientCAs = pool
+	return cfg, nil
+}
+
+// watch reloads the keypair and CA pool whenever the files change on disk,
+// falling back to a periodic poll on r.refresh for filesystems where
+// fsnotify events aren't delivered (e.g. some overlay/NFS mounts).
+func (r *certReloader) watch() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Unable to start PKI file watcher, falling back to polling every %s: %v", r.refresh, err)
+	} else {
+		defer watcher.Close()
+		dirs := sets.NewString(filepath.Dir(r.certPath), filepath.Dir(r.keyPath), filepath.Dir(r.caPath))
+		for _, dir := range dirs.List() {
+			if err := watcher.Add(dir); err != nil {
+				log.Printf("Unable to watch %s: %v", dir, err)
+			}
+		}
+	}
+
+	ticker := time.NewTicker(r.refresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-watcherEvents(watcher):
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) != 0 {
+				if err := r.reload(); err != nil {
+					log.Printf("Error reloading PKI after %s: %v", event, err)
+				}
+			}
+		case <-ticker.C:
+			if err := r.reload(); err != nil {
+				log.Printf("Error reloading PKI on refresh tick: %v", err)
+			}
+		}
+	}
+}
+
+// watcherEvents returns w.Events, or a nil (never-ready) channel if the
+// watcher failed to start so watch()'s select falls through to the ticker.
+func watcherEvents(w *fsnotify.Watcher) chan fsnotify.Event {
+	if w == nil {
+		return nil
+	}
+	return w.Events
+}
+
@@ -0,0 +1,143 @@
+/*
+Copyright © 2019 Red Hat Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// s3Sink streams results straight into an S3-compatible object store,
+// selected via a s3://bucket/prefix?region=... --sink URI.
+type s3Sink struct {
+	client *s3.Client
+	bucket string
+	prefix string
+
+	mu      sync.Mutex
+	uploads map[string]*s3Upload
+}
+
+type s3Upload struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newS3Sink(u *url.URL) (*s3Sink, error) {
+	ctx := context.Background()
+	var opts []func(*config.LoadOptions) error
+	if region := u.Query().Get("region"); region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load AWS config: %w", err)
+	}
+	return &s3Sink{
+		client:  s3.NewFromConfig(cfg),
+		bucket:  u.Host,
+		prefix:  strings.TrimPrefix(u.Path, "/"),
+		uploads: make(map[string]*s3Upload),
+	}, nil
+}
+
+func (s *s3Sink) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *s3Sink) Create(name string) (io.WriteCloser, error) {
+	pr, pw := io.Pipe()
+	up := &s3Upload{pw: pw, done: make(chan error, 1)}
+
+	uploader := manager.NewUploader(s.client)
+	go func() {
+		_, err := uploader.Upload(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.key(name) + ".partial"),
+			Body:   pr,
+		})
+		up.done <- err
+	}()
+
+	s.mu.Lock()
+	s.uploads[name] = up
+	s.mu.Unlock()
+	return pw, nil
+}
+
+func (s *s3Sink) takeUpload(name string) (*s3Upload, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	up, ok := s.uploads[name]
+	delete(s.uploads, name)
+	return up, ok
+}
+
+func (s *s3Sink) Finalize(name string, _ Metadata) error {
+	up, ok := s.takeUpload(name)
+	if !ok {
+		return fmt.Errorf("no in-flight upload for %s", name)
+	}
+	up.pw.Close()
+	if err := <-up.done; err != nil {
+		return fmt.Errorf("unable to upload %s: %w", name, err)
+	}
+
+	ctx := context.Background()
+	_, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.bucket),
+		CopySource: aws.String(s.bucket + "/" + s.key(name) + ".partial"),
+		Key:        aws.String(s.key(name)),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to commit %s: %w", name, err)
+	}
+	// name is already committed at this point; a failure to clean up the
+	// .partial object is a storage-hygiene issue, not an upload failure.
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name) + ".partial"),
+	})
+	if err != nil {
+		log.Printf("Committed %s but failed to remove its .partial object: %v", name, err)
+	}
+	return nil
+}
+
+func (s *s3Sink) Abort(name string) error {
+	if up, ok := s.takeUpload(name); ok {
+		up.pw.CloseWithError(fmt.Errorf("upload aborted"))
+		<-up.done
+	}
+	_, err := s.client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name) + ".partial"),
+	})
+	return err
+}
@@ -0,0 +1,67 @@
+/*
+Copyright © 2019 Red Hat Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+)
+
+// Metadata describes a result object once it has been fully received, so a
+// ResultSink can decide how to commit it.
+type Metadata struct {
+	Digest string // sha256:<hex> as verified while streaming, empty if the uploader didn't supply one
+	Size   int64
+}
+
+// ResultSink abstracts where resultserver persists received SCAP results, so
+// the HTTP handler in server() doesn't need to know whether it's writing to
+// a mounted PVC or an object store. Create's content isn't visible to
+// readers of name until Finalize is called; this lets callers stream a
+// report to storage while it's still being verified and only commit it once
+// the digest check in server() has passed.
+type ResultSink interface {
+	// Create opens name for writing a not-yet-committed object.
+	Create(name string) (io.WriteCloser, error)
+	// Finalize commits a previously Create'd object, making it visible.
+	Finalize(name string, meta Metadata) error
+	// Abort discards a previously Create'd object that failed to write or
+	// verify, so a half-written object never outlives the failed request.
+	Abort(name string) error
+}
+
+// newResultSink builds a ResultSink from a --sink URI, e.g.
+// file:///var/reports, s3://bucket/prefix?region=us-east-1,
+// gs://bucket/prefix, or azblob://container/prefix?account=myaccount.
+func newResultSink(rawURI string) (ResultSink, error) {
+	u, err := url.Parse(rawURI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --sink URI %q: %w", rawURI, err)
+	}
+	switch u.Scheme {
+	case "", "file":
+		return newFileSink(u.Path)
+	case "s3":
+		return newS3Sink(u)
+	case "gs", "gcs":
+		return newGCSSink(u)
+	case "azblob", "azure":
+		return newAzureSink(u)
+	default:
+		return nil, fmt.Errorf("unsupported --sink scheme %q", u.Scheme)
+	}
+}
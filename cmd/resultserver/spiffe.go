@@ -0,0 +1,73 @@
+/*
+Copyright © 2019 Red Hat Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/spiffe/go-spiffe/v2/spiffeid"
+	"github.com/spiffe/go-spiffe/v2/spiffetls/tlsconfig"
+	"github.com/spiffe/go-spiffe/v2/workloadapi"
+)
+
+// spiffeTLSConfig builds a server tls.Config backed by the SPIFFE Workload
+// API: the server's own SVID, and the trust bundle used to validate
+// scanner clients, are both fetched from SPIRE over SPIFFE_ENDPOINT_SOCKET
+// rather than from the static --tls-server-cert/--tls-ca files or the
+// auto-provisioned CA. This lets operators running SPIRE skip plumbing the
+// compliance-operator CA entirely. The returned config is only valid for
+// the lifetime of the process; resultserver doesn't shut the workload API
+// source down on exit.
+func spiffeTLSConfig(c *config) (*tls.Config, error) {
+	source, err := workloadapi.NewX509Source(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("unable to create SPIFFE X.509 source: %w", err)
+	}
+
+	authorizer, err := spiffeAuthorizer(c)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+
+	return tlsconfig.MTLSServerConfig(source, source, authorizer), nil
+}
+
+// spiffeAuthorizer restricts scanner clients to c.SpiffeAllowedIDs when
+// given, or to any identity in c.SpiffeTrustDomain otherwise.
+func spiffeAuthorizer(c *config) (tlsconfig.Authorizer, error) {
+	if len(c.SpiffeAllowedIDs) > 0 {
+		ids := make([]spiffeid.ID, 0, len(c.SpiffeAllowedIDs))
+		for _, raw := range c.SpiffeAllowedIDs {
+			id, err := spiffeid.FromString(raw)
+			if err != nil {
+				return nil, fmt.Errorf("invalid --spiffe-allowed-id %q: %w", raw, err)
+			}
+			ids = append(ids, id)
+		}
+		return tlsconfig.AuthorizeOneOf(ids...), nil
+	}
+	if c.SpiffeTrustDomain != "" {
+		td, err := spiffeid.TrustDomainFromString(c.SpiffeTrustDomain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --spiffe-trust-domain %q: %w", c.SpiffeTrustDomain, err)
+		}
+		return tlsconfig.AuthorizeMemberOf(td), nil
+	}
+	return nil, fmt.Errorf("--spiffe-trust-domain or --spiffe-allowed-id is required to enable SPIFFE authentication")
+}